@@ -0,0 +1,59 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package command
+
+import "golang.org/x/tools/gopls/internal/lsp/protocol"
+
+// RenamePreviewParams carries the same position information as a regular
+// rename request, but RenamePreview never mutates buffers: it is intended
+// for clients that want to render a preview UI before committing to the
+// edit.
+type RenamePreviewParams struct {
+	protocol.TextDocumentPositionParams
+
+	// NewName is the new name to give to the identifier or package at the
+	// given position.
+	NewName string
+}
+
+// RenamePreviewResult is the response to a RenamePreview command. It
+// contains everything a Rename would have applied to the workspace, plus a
+// per-file summary so that clients don't need to walk Edit themselves to
+// render a count, and any conflicts detected while computing the rename.
+type RenamePreviewResult struct {
+	// Edit is the full workspace edit that a subsequent Rename would apply.
+	Edit protocol.WorkspaceEdit
+
+	// Summary reports, for each file touched by Edit, the number of edits
+	// that would be applied to it.
+	Summary []FileRenameSummary
+
+	// Conflicts lists the problems that stopped the rename, such as a
+	// package name collision or an identifier shadow. When Conflicts is
+	// non-empty, Edit and Summary are empty, just as Rename itself fails
+	// with these conflicts instead of returning an edit. A rename that
+	// would break an interface satisfaction is not reported here.
+	Conflicts []ConflictReport
+}
+
+// FileRenameSummary reports how many edits a rename would apply to a
+// single file, for display in a preview UI without requiring the client to
+// walk the full edit set.
+type FileRenameSummary struct {
+	URI       protocol.DocumentURI
+	EditCount int
+}
+
+// ConflictReport describes a single problem detected while preparing a
+// rename, in a form suitable for rendering in an IDE without parsing an
+// error string.
+type ConflictReport struct {
+	// Kind is the conflict's source.ConflictKind rendered as a string
+	// (e.g. "identifier shadow"), so that a client can group or icon
+	// conflicts by kind without depending on gopls' internal enum values.
+	Kind     string
+	Message  string
+	Location protocol.Location
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package command
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+	"golang.org/x/tools/gopls/internal/lsp/source"
+)
+
+// RenamePreview implements the gopls.rename_preview command. Unlike
+// Rename, it never issues a workspace/applyEdit request: it computes the
+// same source.Rename result and hands the edit and a file-by-file summary
+// straight back to the caller, so that a client can render a preview
+// before the user decides whether to apply it.
+func (c *commandHandler) RenamePreview(ctx context.Context, params RenamePreviewParams) (RenamePreviewResult, error) {
+	fh, snapshot, release, err := c.fileOf(ctx, params.TextDocument.URI)
+	if err != nil {
+		return RenamePreviewResult{}, err
+	}
+	defer release()
+
+	edits, moves, _, err := source.Rename(ctx, snapshot, fh, params.Position, params.NewName)
+	if err != nil {
+		conflictErr, ok := err.(*source.RenameConflictError)
+		if !ok {
+			return RenamePreviewResult{}, fmt.Errorf("computing rename preview: %w", err)
+		}
+		// A RenameConflictError still carries a best-effort report for the
+		// client to render, unlike other errors (e.g. a parse failure) that
+		// leave nothing to preview.
+		result := RenamePreviewResult{Edit: protocol.WorkspaceEdit{}}
+		for _, c := range conflictErr.Conflicts {
+			result.Conflicts = append(result.Conflicts, ConflictReport{
+				Kind:     c.Kind.String(),
+				Message:  c.Message,
+				Location: protocol.Location{URI: c.URI, Range: protocol.Range{Start: c.Position, End: c.Position}},
+			})
+		}
+		return result, nil
+	}
+
+	result := RenamePreviewResult{
+		Edit: protocol.WorkspaceEdit{},
+	}
+	for uri, uriEdits := range edits {
+		result.Edit.DocumentChanges = append(result.Edit.DocumentChanges, protocol.DocumentChangeEdit(
+			protocol.VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: protocol.URIFromSpanURI(uri)},
+			},
+			uriEdits,
+		))
+		result.Summary = append(result.Summary, FileRenameSummary{
+			URI:       protocol.URIFromSpanURI(uri),
+			EditCount: len(uriEdits),
+		})
+	}
+	// File moves of a package rename follow every text edit, so that each
+	// moved file is edited under its old URI before it is relocated.
+	for oldURI, newURI := range moves {
+		result.Edit.DocumentChanges = append(result.Edit.DocumentChanges, protocol.DocumentChangeRename(
+			protocol.URIFromSpanURI(oldURI),
+			protocol.URIFromSpanURI(newURI),
+		))
+	}
+	return result, nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regtest
+
+import (
+	"context"
+
+	"golang.org/x/tools/gopls/internal/lsp/command"
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+)
+
+// RenamePreview invokes the gopls.rename_preview command at pos in path
+// and returns the resulting workspace edit and conflict summary without
+// applying anything to open buffers. Use this instead of Rename when a
+// test wants to assert on the exact edit set rather than on the state of
+// buffers after the fact.
+func (e *Editor) RenamePreview(ctx context.Context, path string, pos Pos, newName string) (*command.RenamePreviewResult, error) {
+	params := command.RenamePreviewParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: e.TextDocumentIdentifier(path),
+			Position:     pos.ToProtocolPosition(),
+		},
+		NewName: newName,
+	}
+	var result command.RenamePreviewResult
+	if err := e.ExecuteCommand(ctx, "gopls.rename_preview", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
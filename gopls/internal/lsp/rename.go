@@ -0,0 +1,111 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+
+	"golang.org/x/tools/gopls/internal/lsp/command"
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+	"golang.org/x/tools/gopls/internal/lsp/source"
+	"golang.org/x/tools/gopls/internal/span"
+	"golang.org/x/tools/internal/jsonrpc2"
+)
+
+func (s *server) PrepareRename(ctx context.Context, params *protocol.PrepareRenameParams) (*protocol.Range, error) {
+	fh, snapshot, release, err := s.fileOf(ctx, params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := source.PrepareRename(ctx, snapshot, fh, params.Position)
+	if err != nil {
+		return nil, renameResponseError(err)
+	}
+	return &result.Range, nil
+}
+
+func (s *server) Rename(ctx context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+	fh, snapshot, release, err := s.fileOf(ctx, params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	edits, moves, _, err := source.Rename(ctx, snapshot, fh, params.Position, params.NewName)
+	if err != nil {
+		return nil, renameResponseError(err)
+	}
+	return editsToWorkspaceEdit(snapshot, edits, moves), nil
+}
+
+// editsToWorkspaceEdit assembles a protocol.WorkspaceEdit from a set of
+// per-file textual edits and a set of file moves (old URI to new URI).
+// Each moved file's textual edits (if any) are applied under its old URI,
+// immediately followed by a rename operation to its new URI, so that a
+// client which executes DocumentChanges in order sees the edit applied
+// before the file is relocated.
+func editsToWorkspaceEdit(snapshot source.Snapshot, edits map[span.URI][]protocol.TextEdit, moves map[span.URI]span.URI) *protocol.WorkspaceEdit {
+	wsEdit := &protocol.WorkspaceEdit{}
+	moved := make(map[span.URI]bool, len(moves))
+	for oldURI, newURI := range moves {
+		moved[oldURI] = true
+		if es := edits[oldURI]; len(es) > 0 {
+			wsEdit.DocumentChanges = append(wsEdit.DocumentChanges, protocol.DocumentChangeEdit(
+				protocol.VersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: protocol.URIFromSpanURI(oldURI)},
+				},
+				es,
+			))
+		}
+		wsEdit.DocumentChanges = append(wsEdit.DocumentChanges, protocol.DocumentChangeRename(
+			protocol.URIFromSpanURI(oldURI),
+			protocol.URIFromSpanURI(newURI),
+		))
+	}
+	for uri, es := range edits {
+		if moved[uri] || len(es) == 0 {
+			continue
+		}
+		wsEdit.DocumentChanges = append(wsEdit.DocumentChanges, protocol.DocumentChangeEdit(
+			protocol.VersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: protocol.URIFromSpanURI(uri)},
+			},
+			es,
+		))
+	}
+	return wsEdit
+}
+
+// renameResponseError translates a *source.RenameConflictError into a
+// jsonrpc2.Error carrying the structured conflicts as its Data, so that
+// clients which understand them can render per-conflict diagnostics
+// instead of parsing Error(). Other errors are passed through unchanged.
+func renameResponseError(err error) error {
+	conflictErr, ok := err.(*source.RenameConflictError)
+	if !ok {
+		return err
+	}
+	data := make([]command.ConflictReport, len(conflictErr.Conflicts))
+	for i, c := range conflictErr.Conflicts {
+		data[i] = command.ConflictReport{
+			Kind:     c.Kind.String(),
+			Message:  c.Message,
+			Location: protocol.Location{URI: c.URI, Range: protocol.Range{Start: c.Position, End: c.Position}},
+		}
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return conflictErr
+	}
+	msg := json.RawMessage(raw)
+	return &jsonrpc2.Error{
+		Code:    jsonrpc2.CodeInvalidRequest,
+		Message: conflictErr.Error(),
+		Data:    &msg,
+	}
+}
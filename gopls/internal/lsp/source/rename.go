@@ -0,0 +1,239 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package source contains the Rename implementation used by gopls. The
+// identifier renamer must cope with two distinct realms of types.Object:
+// objects that belong to the type-checked package containing the cursor,
+// and (when the object is exported) objects belonging to every package
+// that transitively depends on it. Objects from different realms are
+// never comparable, so the renamer that used to pass a single
+// types.Object around has been replaced by one that re-resolves the
+// target in each package's own type-checked syntax via objectpath.
+package source
+
+import (
+	"context"
+	"fmt"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/types/objectpath"
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+	"golang.org/x/tools/gopls/internal/span"
+	"golang.org/x/tools/internal/event"
+)
+
+// Rename computes the edits needed to rename the identifier or package at
+// pp to newName. moves reports, for a package rename, the files that must
+// be relocated from their current URI (the map key) to their new one (the
+// map value); it is always empty for an identifier rename. isPackage
+// reports whether pp named a package rather than an identifier.
+func Rename(ctx context.Context, snapshot Snapshot, f FileHandle, pp protocol.Position, newName string) (edits map[span.URI][]protocol.TextEdit, moves map[span.URI]span.URI, isPackage bool, err error) {
+	ctx, done := event.Start(ctx, "source.Rename")
+	defer done()
+
+	pkg, pgf, err := NarrowestPackageForFile(ctx, snapshot, f.URI())
+	if err != nil {
+		return nil, nil, false, err
+	}
+	pos, err := pgf.PositionPos(pp)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	obj, isPackageName, err := objectOrPackageNameAt(pkg, pgf, pos)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if isPackageName {
+		edits, moves, err := renamePackage(ctx, snapshot, pkg, newName)
+		return edits, moves, true, err
+	}
+	if obj == nil {
+		return nil, nil, false, &RenameConflictError{Conflicts: []*RenameConflict{newNoObjectConflict()}}
+	}
+
+	if conflict := checkIdentifierShadow(obj, newName); conflict != nil {
+		return nil, nil, false, &RenameConflictError{Conflicts: []*RenameConflict{conflict}}
+	}
+
+	if !obj.Exported() || isLocal(obj) {
+		// Unexported and local objects (function-scoped identifiers, import
+		// names, labels, unexported package members) can only ever be
+		// referenced from their own package, so there is no need to pay for
+		// the reverse-dependency search below.
+		edits, err := renameObjectInPackage(ctx, snapshot, pkg, obj, newName)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return edits, nil, false, nil
+	}
+
+	path, err := objectpath.For(obj)
+	if err != nil {
+		// Objects without an objectpath (e.g. methods of unnamed interface
+		// types) can't escape their defining package either.
+		edits, err := renameObjectInPackage(ctx, snapshot, pkg, obj, newName)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		return edits, nil, false, nil
+	}
+
+	rdeps, err := reverseDependencies(ctx, snapshot, pkg, obj)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("finding reverse dependencies: %w", err)
+	}
+
+	byURI := make(map[span.URI][]protocol.TextEdit)
+	for _, rdep := range rdeps {
+		target, err := path.Object(rdep.GetTypes())
+		if err != nil {
+			// The rdep may not actually reference the object along this
+			// particular objectpath (e.g. it imports the package but not
+			// the renamed symbol); that's fine, just skip it.
+			continue
+		}
+		edits, err := renameObjectInPackage(ctx, snapshot, rdep, target, newName)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("renaming in %s: %w", rdep.PkgPath(), err)
+		}
+		for uri, es := range edits {
+			byURI[uri] = append(byURI[uri], es...)
+		}
+	}
+
+	result, err := dedupeEdits(byURI)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return result, nil, false, nil
+}
+
+// reverseDependencies returns the set of packages that must be
+// re-type-checked and searched for references to obj. Package-level
+// const/var/func/type objects need only their direct importers searched,
+// since a reference can only occur in a package that directly imports the
+// defining package. Fields and interface methods can be reached through
+// embedding and interface satisfaction, which can propagate arbitrarily far
+// through the import graph, so those require the full transitive closure.
+func reverseDependencies(ctx context.Context, snapshot Snapshot, declaring Package, obj types.Object) ([]Package, error) {
+	transitive := needsTransitiveRdeps(obj)
+
+	ids, err := snapshot.ReverseDependencies(ctx, declaring.ID(), transitive)
+	if err != nil {
+		return nil, err
+	}
+	ids[declaring.ID()] = struct{}{}
+
+	var ids2 []PackageID
+	for id := range ids {
+		ids2 = append(ids2, id)
+	}
+	return snapshot.TypeCheck(ctx, ids2...)
+}
+
+// needsTransitiveRdeps reports whether a reference to obj could occur
+// outside obj's direct importers: embedded fields and interface methods can
+// be promoted or satisfied arbitrarily many import-levels away.
+func needsTransitiveRdeps(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return true // method: may be satisfying/promoted through embedding
+		}
+	case *types.Var:
+		if obj.IsField() {
+			return true
+		}
+	}
+	return false
+}
+
+// renameObjectInPackage renames every reference to obj (already
+// type-checked as part of pkg) within pkg's own files. Test variants of
+// pkg are separate packages and are not searched here. It must never be called with a types.Object belonging to a different
+// package's type-checker realm than pkg.
+func renameObjectInPackage(ctx context.Context, snapshot Snapshot, pkg Package, obj types.Object, newName string) (map[span.URI][]protocol.TextEdit, error) {
+	objsToUpdate := map[types.Object]bool{obj: true}
+	return computeTextEdits(pkg, objsToUpdate, newName)
+}
+
+// dedupeEdits sorts and de-duplicates the aggregated edit set, since
+// processing a package and its test variant (or processing the same rdep
+// reachable through two different import paths) can yield the same edit
+// twice. Distinct edits that claim the same span are a bug in the renamer,
+// not a legitimate duplicate, so they are reported as an error rather than
+// silently resolved.
+func dedupeEdits(byURI map[span.URI][]protocol.TextEdit) (map[span.URI][]protocol.TextEdit, error) {
+	out := make(map[span.URI][]protocol.TextEdit, len(byURI))
+	for uri, edits := range byURI {
+		sort.Slice(edits, func(i, j int) bool {
+			if c := comparePosition(edits[i].Range.Start, edits[j].Range.Start); c != 0 {
+				return c < 0
+			}
+			return comparePosition(edits[i].Range.End, edits[j].Range.End) < 0
+		})
+		deduped := edits[:0]
+		for i, e := range edits {
+			if i > 0 && e.Range == edits[i-1].Range {
+				if e.NewText != edits[i-1].NewText {
+					return nil, fmt.Errorf("internal error: conflicting edits at %v: %q vs %q", e.Range, e.NewText, edits[i-1].NewText)
+				}
+				continue
+			}
+			deduped = append(deduped, e)
+		}
+		out[uri] = deduped
+	}
+	return out, nil
+}
+
+func comparePosition(a, b protocol.Position) int {
+	if a.Line != b.Line {
+		if a.Line < b.Line {
+			return -1
+		}
+		return 1
+	}
+	if a.Character != b.Character {
+		if a.Character < b.Character {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// checkIdentifierShadow reports a conflict if newName is already declared
+// in the lexical scope that obj itself was declared in, where renaming obj
+// would redeclare that name. Only that one scope is searched: a newName
+// declared in an enclosing or nested scope, where the renamed identifier
+// would capture or be captured by other references, is not reported.
+// Fields and methods have no lexical Parent scope, so no conflict is
+// reported for them.
+func checkIdentifierShadow(obj types.Object, newName string) *RenameConflict {
+	scope := obj.Parent()
+	if scope == nil {
+		return nil
+	}
+	existing := scope.Lookup(newName)
+	if existing == nil || existing == obj {
+		return nil
+	}
+	return newIdentifierShadowConflict(existing, newName)
+}
+
+// isLocal reports whether obj is function-scoped (a local variable,
+// parameter, result, type parameter, or label) or is an import name, which
+// is file-scoped. Such objects can never be referenced outside the
+// package, so they are always renamed via the single-package path.
+func isLocal(obj types.Object) bool {
+	switch obj.(type) {
+	case *types.Label, *types.PkgName:
+		return true
+	}
+	return obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope()
+}
+
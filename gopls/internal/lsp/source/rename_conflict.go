@@ -0,0 +1,142 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package source
+
+import (
+	"fmt"
+	"go/types"
+
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+)
+
+// ConflictKind classifies why a rename cannot proceed.
+type ConflictKind int
+
+const (
+	// ConflictUnknown is the zero value and should never appear in a
+	// returned RenameConflict.
+	ConflictUnknown ConflictKind = iota
+
+	// InvalidTarget indicates that the thing under the cursor cannot be
+	// renamed at all, independent of the chosen name: there is no object
+	// there, it names the "main" package, or its package's module
+	// couldn't be determined.
+	InvalidTarget
+
+	// IdentifierShadow indicates that newName is already bound in the
+	// scope that declares the renamed object, so applying the rename
+	// would redeclare that name.
+	IdentifierShadow
+
+	// PackageNameCollision indicates that renaming a package's directory
+	// to newName would move it on top of a directory that already
+	// exists, which would silently clobber or merge with that
+	// directory's files rather than producing the renamed package.
+	PackageNameCollision
+
+	// TODO: the request that introduced this type also asked for a
+	// MethodSetViolation kind (renaming breaks an interface satisfaction
+	// relationship) and a NewlyExported kind (rename crosses the
+	// exported/unexported boundary). Both require analysis this package
+	// doesn't yet do — respectively, checking type-assignability across
+	// every reverse dependency before and after the rename, and deciding
+	// whether crossing that boundary should block a rename at all rather
+	// than just being informational — and are left as follow-up work.
+)
+
+func (k ConflictKind) String() string {
+	switch k {
+	case InvalidTarget:
+		return "invalid rename target"
+	case IdentifierShadow:
+		return "identifier shadow"
+	case PackageNameCollision:
+		return "package name collision"
+	default:
+		return "unknown conflict"
+	}
+}
+
+// RenameConflict is returned (often as one of several, wrapped in a
+// RenameConflictError) when a rename cannot be safely applied as asked.
+// Unlike a plain error string, its fields let an IDE group conflicts by
+// Kind without re-parsing Message.
+type RenameConflict struct {
+	Kind ConflictKind
+
+	// Position and URI locate the conflict. The conflicts built in this
+	// file leave both unset (the zero Position and an empty URI).
+	Position protocol.Position
+	URI      protocol.DocumentURI
+	Message  string
+
+	// ExistingObject is the declaration that newName already names in the
+	// conflicting scope, for an IdentifierShadow conflict. It is nil for
+	// conflicts, such as InvalidTarget, that don't involve a second
+	// declaration.
+	ExistingObject types.Object
+}
+
+func (c *RenameConflict) Error() string {
+	return c.Message
+}
+
+// RenameConflictError wraps one or more RenameConflicts discovered while
+// preparing a rename. Callers that only care about whether the rename
+// succeeded can treat it as a plain error; callers that want to render
+// structured diagnostics (e.g. the LSP layer, via ResponseError.Data) can
+// type-assert for *RenameConflictError and walk Conflicts.
+type RenameConflictError struct {
+	Conflicts []*RenameConflict
+}
+
+func (e *RenameConflictError) Error() string {
+	if len(e.Conflicts) == 1 {
+		return e.Conflicts[0].Message
+	}
+	return fmt.Sprintf("%s (and %d more conflict(s))", e.Conflicts[0].Message, len(e.Conflicts)-1)
+}
+
+func newUnknownModuleConflict(pkgPath string) *RenameConflict {
+	return &RenameConflict{
+		Kind:    InvalidTarget,
+		Message: fmt.Sprintf("can't rename package: missing module information for package %s", pkgPath),
+	}
+}
+
+func newNoObjectConflict() *RenameConflict {
+	return &RenameConflict{
+		Kind:    InvalidTarget,
+		Message: "no object found",
+	}
+}
+
+func newMainPackageConflict() *RenameConflict {
+	return &RenameConflict{
+		Kind:    InvalidTarget,
+		Message: `can't rename package "main"`,
+	}
+}
+
+// newIdentifierShadowConflict reports that newName is already declared in
+// the scope that obj's declaration belongs to, as existing, so renaming
+// obj to newName would redeclare existing's name rather than introducing
+// a fresh name.
+func newIdentifierShadowConflict(existing types.Object, newName string) *RenameConflict {
+	return &RenameConflict{
+		Kind:           IdentifierShadow,
+		Message:        fmt.Sprintf("renaming to %q would conflict with an existing declaration in the same scope", newName),
+		ExistingObject: existing,
+	}
+}
+
+// newPackageNameCollisionConflict reports that the directory a package
+// rename would move newName's files into already exists.
+func newPackageNameCollisionConflict(newDir string, newName string) *RenameConflict {
+	return &RenameConflict{
+		Kind:    PackageNameCollision,
+		Message: fmt.Sprintf("can't rename package to %q: directory %s already exists", newName, newDir),
+	}
+}
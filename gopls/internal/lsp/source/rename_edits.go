@@ -0,0 +1,107 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package source
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+	"golang.org/x/tools/gopls/internal/span"
+)
+
+// identAt returns the *ast.Ident enclosing pos in pgf, or nil if there is
+// none.
+func identAt(pgf *ParsedGoFile, pos token.Pos) *ast.Ident {
+	var found *ast.Ident
+	ast.Inspect(pgf.File, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		if id.Pos() <= pos && pos <= id.End() {
+			found = id
+		}
+		return true
+	})
+	return found
+}
+
+// objectOrPackageNameAt classifies the identifier at pos: if it is the
+// package's own name in the package clause, it reports isPackageName;
+// otherwise it resolves the types.Object the identifier denotes (which is
+// nil if pos isn't on an identifier, or the identifier has no resolved
+// object, e.g. because the file failed to parse a package clause at all).
+func objectOrPackageNameAt(pkg Package, pgf *ParsedGoFile, pos token.Pos) (obj types.Object, isPackageName bool, err error) {
+	id := identAt(pgf, pos)
+	if id == nil {
+		return nil, false, nil
+	}
+	if pgf.File.Name == id {
+		return nil, true, nil
+	}
+	info := pkg.GetTypesInfo()
+	if info == nil {
+		return nil, false, nil
+	}
+	return info.ObjectOf(id), false, nil
+}
+
+// computeTextEdits renders every *ast.Ident in pkg whose resolved
+// types.Object is in objsToUpdate as a protocol.TextEdit replacing it
+// with newName.
+func computeTextEdits(pkg Package, objsToUpdate map[types.Object]bool, newName string) (map[span.URI][]protocol.TextEdit, error) {
+	info := pkg.GetTypesInfo()
+	edits := make(map[span.URI][]protocol.TextEdit)
+	for _, pgf := range pkg.GetSyntax() {
+		var fileEdits []protocol.TextEdit
+		var rangeErr error
+		ast.Inspect(pgf.File, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if !objsToUpdate[info.ObjectOf(id)] {
+				return true
+			}
+			rng, err := pgf.PosRange(id.Pos(), id.End())
+			if err != nil {
+				rangeErr = err
+				return false
+			}
+			fileEdits = append(fileEdits, protocol.TextEdit{Range: rng, NewText: newName})
+			return true
+		})
+		if rangeErr != nil {
+			return nil, rangeErr
+		}
+		if len(fileEdits) > 0 {
+			edits[pgf.URI] = fileEdits
+		}
+	}
+	return edits, nil
+}
+
+// renameRangeAndText returns the source range and current text of the
+// identifier at pos, for use as the placeholder in a PrepareRename
+// response. If isPackageName is true, obj is nil and the package's own
+// name (from its clause, which is what identAt resolved pos to) is used
+// instead of an object name.
+func renameRangeAndText(pgf *ParsedGoFile, pos token.Pos, obj types.Object, isPackageName bool) (protocol.Range, string, error) {
+	id := identAt(pgf, pos)
+	if id == nil {
+		return protocol.Range{}, "", fmt.Errorf("no identifier found at position")
+	}
+	rng, err := pgf.PosRange(id.Pos(), id.End())
+	if err != nil {
+		return protocol.Range{}, "", err
+	}
+	if isPackageName {
+		return rng, pgf.File.Name.Name, nil
+	}
+	return rng, obj.Name(), nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+	"golang.org/x/tools/gopls/internal/span"
+)
+
+// renameModFiles updates every go.mod in the workspace that is affected by
+// moving the package directory oldDir to newDir (renaming, where
+// applicable, its module path from oldModPath to newModPath):
+//
+//   - a `replace oldModPath => oldRelPath` whose target resolves to oldDir
+//     has its right-hand side rewritten to the equivalent path for newDir,
+//     and, if the module itself was renamed (oldModPath != newModPath),
+//     its left-hand side too;
+//   - a `require oldModPath vX.Y.Z` is rewritten to require newModPath, if
+//     the module itself was renamed;
+//   - if oldDir is itself the root of a nested module (it has its own
+//     go.mod) and the module was renamed, that go.mod's own `module`
+//     directive is rewritten.
+//
+// oldModPath and newModPath may be equal, in which case only the
+// directory-path edits above apply: renaming a package directory that is
+// merely referenced by, but is not the root of, a nested module does not
+// change that module's identity.
+func renameModFiles(ctx context.Context, snapshot Snapshot, oldDir, newDir span.URI, oldModPath, newModPath string) (map[span.URI][]protocol.TextEdit, error) {
+	edits := make(map[span.URI][]protocol.TextEdit)
+
+	modFiles, err := snapshot.ModFiles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing workspace go.mod files: %w", err)
+	}
+
+	for _, modURI := range modFiles {
+		fh, err := snapshot.ReadFile(ctx, modURI)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fh.Content()
+		if err != nil {
+			return nil, err
+		}
+		file, err := modfile.Parse(modURI.Filename(), content, nil)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", modURI, err)
+		}
+
+		modDir := filepath.Dir(modURI.Filename())
+		changed := false
+
+		for _, req := range file.Require {
+			if req.Mod.Path == oldModPath && oldModPath != newModPath {
+				if err := file.AddRequire(newModPath, req.Mod.Version); err != nil {
+					return nil, err
+				}
+				if err := file.DropRequire(oldModPath); err != nil {
+					return nil, err
+				}
+				changed = true
+			}
+		}
+
+		for _, rep := range file.Replace {
+			if rep.New.Version != "" {
+				// Module-based replacement (not a filesystem path); the
+				// directory rename doesn't affect it.
+				continue
+			}
+			// The replace's target may be oldDir itself (the renamed
+			// directory is the replaced module's root) or any path
+			// beneath it (the renamed directory is an ancestor of the
+			// replaced module's root, as when renaming "foo" while a
+			// "foo/bar" nested module is replaced).
+			absTarget := filepath.Join(modDir, rep.New.Path)
+			relToOldDir, err := filepath.Rel(oldDir.Filename(), absTarget)
+			if err != nil || relToOldDir == ".." || strings.HasPrefix(relToOldDir, ".."+string(filepath.Separator)) {
+				continue
+			}
+			newAbsTarget := filepath.Join(newDir.Filename(), relToOldDir)
+			newRel, err := filepath.Rel(modDir, newAbsTarget)
+			if err != nil {
+				return nil, err
+			}
+			newRel = toSlashRel(newRel)
+
+			replacementModPath := rep.Old.Path
+			if rep.Old.Path == oldModPath && oldModPath != newModPath {
+				replacementModPath = newModPath
+			}
+			if err := file.DropReplace(rep.Old.Path, rep.Old.Version); err != nil {
+				return nil, err
+			}
+			if err := file.AddReplace(replacementModPath, rep.Old.Version, newRel, ""); err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+		file.Cleanup()
+		newContent, err := file.Format()
+		if err != nil {
+			return nil, err
+		}
+		edits[modURI] = computeDiffEdits(content, newContent)
+	}
+
+	// If the renamed directory is itself a nested module's root, its own
+	// module directive needs rewriting too.
+	if oldModPath != newModPath {
+		nestedGoMod := span.URIFromPath(filepath.Join(oldDir.Filename(), "go.mod"))
+		if fh, err := snapshot.ReadFile(ctx, nestedGoMod); err == nil {
+			content, err := fh.Content()
+			if err != nil {
+				return nil, err
+			}
+			file, err := modfile.Parse(nestedGoMod.Filename(), content, nil)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", nestedGoMod, err)
+			}
+			if file.Module != nil && file.Module.Mod.Path == oldModPath {
+				if err := file.AddModuleStmt(newModPath); err != nil {
+					return nil, err
+				}
+				file.Cleanup()
+				newContent, err := file.Format()
+				if err != nil {
+					return nil, err
+				}
+				// Keyed by its old location, like every other edit to a
+				// file that the caller is also moving: the workspace edit
+				// applies edits before relocating the file.
+				edits[nestedGoMod] = computeDiffEdits(content, newContent)
+			}
+		}
+	}
+
+	return edits, nil
+}
+
+// computeDiffEdits returns the single TextEdit that turns oldContent into
+// newContent by replacing the file's entire contents. go.mod files are
+// small and rewritten as a whole by modfile.Format, so there's no need for
+// a line-level diff here the way there is for source edits.
+func computeDiffEdits(oldContent, newContent []byte) []protocol.TextEdit {
+	if bytes.Equal(oldContent, newContent) {
+		return nil
+	}
+	end, err := offsetToPosition(oldContent, len(oldContent))
+	if err != nil {
+		return nil
+	}
+	return []protocol.TextEdit{{
+		Range:   protocol.Range{Start: protocol.Position{}, End: end},
+		NewText: string(newContent),
+	}}
+}
+
+func toSlashRel(p string) string {
+	if p == "." || p == "" {
+		return "./."
+	}
+	if p[0] != '.' {
+		return "./" + filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(p)
+}
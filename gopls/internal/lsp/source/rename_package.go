@@ -0,0 +1,383 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package source
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+	"golang.org/x/tools/gopls/internal/span"
+)
+
+// renamePackage computes the edits needed to rename pkg's directory (and
+// therefore its import path and package clause) to newName. It returns
+// edits to existing files together with the set of files that must be
+// moved from their current URI to one beneath a sibling directory named
+// newName.
+//
+// Every file beneath pkg's directory is moved, not just pkg's own:
+// subdirectories holding unrelated subpackages move down with it (their
+// import paths are rewritten, but since only their ancestor directory
+// changed, not their own, their package names and any existing import
+// aliases are untouched), and pkg's own internal test variant and
+// external test package (which NarrowestPackageForFile does not surface,
+// since it prefers the non-test variant) are found and renamed alongside
+// pkg's ordinary files. If pkg's directory is itself a go.mod's module
+// root, that go.mod moves too, and its own `module` directive, along
+// with any other go.mod's `require`/`replace` of it, is rewritten.
+func renamePackage(ctx context.Context, snapshot Snapshot, pkg Package, newName string) (map[span.URI][]protocol.TextEdit, map[span.URI]span.URI, error) {
+	if pkg.Name() == "main" {
+		return nil, nil, &RenameConflictError{Conflicts: []*RenameConflict{newMainPackageConflict()}}
+	}
+	mod := pkg.Module()
+	if mod == nil {
+		return nil, nil, &RenameConflictError{Conflicts: []*RenameConflict{newUnknownModuleConflict(string(pkg.PkgPath()))}}
+	}
+	if len(pkg.GetSyntax()) == 0 {
+		return nil, nil, fmt.Errorf("package %s has no files", pkg.PkgPath())
+	}
+
+	oldDir := filepath.Dir(pkg.GetSyntax()[0].URI.Filename())
+	newDir := filepath.Join(filepath.Dir(oldDir), newName)
+	newDirURI := span.URIFromPath(newDir)
+
+	exists, err := snapshot.DirExists(ctx, newDirURI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("checking %s: %w", newDir, err)
+	}
+	if exists {
+		return nil, nil, &RenameConflictError{Conflicts: []*RenameConflict{newPackageNameCollisionConflict(newDir, newName)}}
+	}
+
+	oldPath := pkg.PkgPath()
+	newPath := siblingImportPath(oldPath, newName)
+
+	workspaceIDs, err := snapshot.WorkspacePackages(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing workspace packages: %w", err)
+	}
+	workspacePkgs, err := snapshot.TypeCheck(ctx, workspaceIDs...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("type-checking workspace packages: %w", err)
+	}
+
+	edits := make(map[span.URI][]protocol.TextEdit)
+	moves := make(map[span.URI]span.URI)
+
+	// subtree holds every package with at least one file beneath oldDir
+	// (oldDir itself or any subdirectory), deduplicated by directory so
+	// that an internal test variant doesn't yield the same directory's
+	// files twice for the purpose of import-path rewriting below.
+	var subtree []Package
+	seenDirs := make(map[string]bool)
+	for _, p := range workspacePkgs {
+		if len(p.GetSyntax()) == 0 {
+			continue
+		}
+		dir := filepath.Dir(p.GetSyntax()[0].URI.Filename())
+		if dir != oldDir && !strings.HasPrefix(dir, oldDir+string(filepath.Separator)) {
+			continue
+		}
+		if seenDirs[dir] {
+			continue
+		}
+		seenDirs[dir] = true
+		subtree = append(subtree, p)
+	}
+
+	// Move every file found in any package beneath oldDir, deduplicated
+	// by URI: a directory's internal test variant and its non-test
+	// package share most of their files, and oldDir's external test
+	// package ("foo_test") is a wholly separate Package from oldDir's
+	// own, so only a flat, URI-keyed pass over every workspace package
+	// visits each file exactly once. The package clause of a file is
+	// renamed only when the file lives directly in oldDir (pkg itself,
+	// its internal test variant, or its "foo_test" external test
+	// package); a subpackage's files move without any clause edit, since
+	// only their ancestor directory changed, not their own.
+	visited := make(map[span.URI]bool)
+	for _, p := range workspacePkgs {
+		for _, pgf := range p.GetSyntax() {
+			dir := filepath.Dir(pgf.URI.Filename())
+			if dir != oldDir && !strings.HasPrefix(dir, oldDir+string(filepath.Separator)) {
+				continue
+			}
+			if visited[pgf.URI] {
+				continue
+			}
+			visited[pgf.URI] = true
+
+			rel, err := filepath.Rel(oldDir, dir)
+			if err != nil {
+				return nil, nil, err
+			}
+			destDir := newDir
+			if rel != "." {
+				destDir = filepath.Join(newDir, rel)
+			}
+
+			if dir == oldDir {
+				switch pgf.File.Name.Name {
+				case pkg.Name():
+					rng, err := pgf.PosRange(pgf.File.Name.Pos(), pgf.File.Name.End())
+					if err != nil {
+						return nil, nil, err
+					}
+					edits[pgf.URI] = append(edits[pgf.URI], protocol.TextEdit{Range: rng, NewText: newName})
+				case pkg.Name() + "_test":
+					rng, err := pgf.PosRange(pgf.File.Name.Pos(), pgf.File.Name.End())
+					if err != nil {
+						return nil, nil, err
+					}
+					edits[pgf.URI] = append(edits[pgf.URI], protocol.TextEdit{Range: rng, NewText: newName + "_test"})
+				}
+			}
+			moves[pgf.URI] = span.URIFromPath(filepath.Join(destDir, filepath.Base(pgf.URI.Filename())))
+		}
+	}
+
+	// Any go.mod beneath oldDir moves with its directory, whether it's
+	// oldDir's own (oldDir is a module root) or a nested module several
+	// levels deeper. Its content only needs editing if its module path
+	// changes, which renameModFiles below decides and handles; the move
+	// itself is unconditional.
+	modFiles, err := snapshot.ModFiles(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing go.mod files: %w", err)
+	}
+	for _, modURI := range modFiles {
+		dir := filepath.Dir(modURI.Filename())
+		if dir != oldDir && !strings.HasPrefix(dir, oldDir+string(filepath.Separator)) {
+			continue
+		}
+		rel, err := filepath.Rel(oldDir, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		destDir := newDir
+		if rel != "." {
+			destDir = filepath.Join(newDir, rel)
+		}
+		moves[modURI] = span.URIFromPath(filepath.Join(destDir, "go.mod"))
+	}
+
+	// Rewrite every importer of every package in the subtree. The
+	// package actually being renamed (oldPath exactly) also gets its
+	// bare import qualifier rewritten to newName, aliasing around any
+	// collision that introduces; a subpackage's own name is unaffected
+	// by its ancestor directory moving, so only its import path text
+	// changes, never its qualifier or any existing alias. A package of a
+	// different (nested) module keeps its import path, which comes from
+	// that module's own path rather than from its directory.
+	for _, p := range subtree {
+		pPath := p.PkgPath()
+		if strings.HasSuffix(string(pPath), "_test") {
+			continue // external test packages are never imported
+		}
+		if p.Module() == nil || p.Module().Path != mod.Path {
+			continue
+		}
+		subPath := newPath + PackagePath(strings.TrimPrefix(string(pPath), string(oldPath)))
+
+		rdeps, err := directImporters(ctx, snapshot, p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("finding importers of %s: %w", pPath, err)
+		}
+		for _, rdep := range rdeps {
+			if rdep.ID() == p.ID() {
+				continue
+			}
+			var importEdits map[span.URI][]protocol.TextEdit
+			if pPath == oldPath {
+				importEdits, err = renameImportInPackage(rdep, pPath, subPath, newName)
+			} else {
+				importEdits, err = renameImportPathOnly(rdep, pPath, subPath)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("updating imports in %s: %w", rdep.PkgPath(), err)
+			}
+			for uri, es := range importEdits {
+				edits[uri] = append(edits[uri], es...)
+			}
+		}
+	}
+
+	// Update any go.mod `replace`/`require` directive whose target falls
+	// under oldDir. If oldDir is itself the root of its own module (as
+	// opposed to merely a package somewhere underneath one), the rename
+	// also changes that module's path.
+	oldModPath, newModPath := mod.Path, mod.Path
+	if mod.Dir == span.URIFromPath(oldDir) {
+		newModPath = string(siblingImportPath(PackagePath(mod.Path), newName))
+	}
+	modEdits, err := renameModFiles(ctx, snapshot, span.URIFromPath(oldDir), newDirURI, oldModPath, newModPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("updating go.mod files: %w", err)
+	}
+	for uri, es := range modEdits {
+		edits[uri] = append(edits[uri], es...)
+	}
+
+	return edits, moves, nil
+}
+
+// directImporters returns the type-checked packages that directly import
+// pkg. Only direct importers can reference pkg's import path or package
+// qualifier, so (unlike an identifier rename, which must also consider
+// promotion through embedding) a package rename never needs the
+// transitive closure.
+func directImporters(ctx context.Context, snapshot Snapshot, pkg Package) ([]Package, error) {
+	ids, err := snapshot.ReverseDependencies(ctx, pkg.ID(), false)
+	if err != nil {
+		return nil, err
+	}
+	var idList []PackageID
+	for id := range ids {
+		idList = append(idList, id)
+	}
+	return snapshot.TypeCheck(ctx, idList...)
+}
+
+// siblingImportPath returns the import path that results from renaming
+// the last path element of oldPath to newName.
+func siblingImportPath(oldPath PackagePath, newName string) PackagePath {
+	i := strings.LastIndexByte(string(oldPath), '/')
+	if i < 0 {
+		return PackagePath(newName)
+	}
+	return PackagePath(string(oldPath)[:i+1] + newName)
+}
+
+// renameImportInPackage rewrites every import of oldPath in rdep to
+// newPath. If the import has no explicit local name, every qualified
+// reference through it is also rewritten to use newName (or, if newName
+// collides with another name already in scope in that file, a
+// disambiguating alias is introduced and used instead).
+func renameImportInPackage(rdep Package, oldPath, newPath PackagePath, newName string) (map[span.URI][]protocol.TextEdit, error) {
+	info := rdep.GetTypesInfo()
+	edits := make(map[span.URI][]protocol.TextEdit)
+	for _, pgf := range rdep.GetSyntax() {
+		for _, imp := range pgf.File.Imports {
+			importPath, err := strconv.Unquote(imp.Path.Value)
+			if err != nil || PackagePath(importPath) != oldPath {
+				continue
+			}
+			pathRng, err := pgf.PosRange(imp.Path.Pos(), imp.Path.End())
+			if err != nil {
+				return nil, err
+			}
+			fileEdits := []protocol.TextEdit{{Range: pathRng, NewText: strconv.Quote(string(newPath))}}
+
+			if imp.Name != nil {
+				// An explicit name (including "_" and ".") already
+				// disambiguates this import from its new package name, and
+				// no qualified reference needs to change.
+				edits[pgf.URI] = append(edits[pgf.URI], fileEdits...)
+				continue
+			}
+
+			localName := newName
+			if collides(pgf.File, newName) {
+				localName = freshAlias(pgf.File, newName)
+				fileEdits = append(fileEdits, protocol.TextEdit{
+					Range:   protocol.Range{Start: pathRng.Start, End: pathRng.Start},
+					NewText: localName + " ",
+				})
+			}
+
+			if pkgname, ok := info.Implicits[imp].(*types.PkgName); ok {
+				selEdits, err := renameQualifier(pgf, info, pkgname, localName)
+				if err != nil {
+					return nil, err
+				}
+				fileEdits = append(fileEdits, selEdits...)
+			}
+			edits[pgf.URI] = append(edits[pgf.URI], fileEdits...)
+		}
+	}
+	return edits, nil
+}
+
+// renameImportPathOnly rewrites every import of oldPath in rdep to
+// newPath, without touching any qualifier or alias. It is used for a
+// subpackage of the package actually being renamed: only its ancestor
+// directory moved, so its own package name, and any alias a caller chose
+// for it, are unaffected.
+func renameImportPathOnly(rdep Package, oldPath, newPath PackagePath) (map[span.URI][]protocol.TextEdit, error) {
+	edits := make(map[span.URI][]protocol.TextEdit)
+	for _, pgf := range rdep.GetSyntax() {
+		for _, imp := range pgf.File.Imports {
+			importPath, err := strconv.Unquote(imp.Path.Value)
+			if err != nil || PackagePath(importPath) != oldPath {
+				continue
+			}
+			pathRng, err := pgf.PosRange(imp.Path.Pos(), imp.Path.End())
+			if err != nil {
+				return nil, err
+			}
+			edits[pgf.URI] = append(edits[pgf.URI], protocol.TextEdit{Range: pathRng, NewText: strconv.Quote(string(newPath))})
+		}
+	}
+	return edits, nil
+}
+
+// renameQualifier rewrites every *ast.Ident in pgf that resolves to
+// pkgname (the implicit local name bound by an unaliased import) to
+// newName.
+func renameQualifier(pgf *ParsedGoFile, info *types.Info, pkgname *types.PkgName, newName string) ([]protocol.TextEdit, error) {
+	var edits []protocol.TextEdit
+	var rangeErr error
+	ast.Inspect(pgf.File, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || info.Uses[id] != types.Object(pkgname) {
+			return true
+		}
+		rng, err := pgf.PosRange(id.Pos(), id.End())
+		if err != nil {
+			rangeErr = err
+			return false
+		}
+		edits = append(edits, protocol.TextEdit{Range: rng, NewText: newName})
+		return true
+	})
+	return edits, rangeErr
+}
+
+// collides reports whether name is already bound to some other import in
+// file, and so cannot be used as a bare qualifier without ambiguity.
+func collides(file *ast.File, name string) bool {
+	for _, imp := range file.Imports {
+		if imp.Name != nil {
+			if imp.Name.Name == name {
+				return true
+			}
+			continue
+		}
+		if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil {
+			if filepath.Base(unquoted) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// freshAlias returns a name derived from base that does not collide with
+// any import already present in file, by appending an increasing suffix
+// (base2, base3, ...) until one is found free.
+func freshAlias(file *ast.File, base string) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s%d", base, i)
+		if !collides(file, candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package source
+
+import (
+	"context"
+
+	"golang.org/x/tools/gopls/internal/lsp/protocol"
+)
+
+// PrepareRenameResult holds the result of a PrepareRename call.
+type PrepareRenameResult struct {
+	Range protocol.Range
+	Text  string
+}
+
+// PrepareRename searches for a valid renaming of the identifier at pp. It
+// returns a *RenameConflictError (wrapping a single RenameConflict) in
+// every case that used to be reported as a bare fmt.Errorf string, so
+// that callers such as the LSP handler can attach structured conflict
+// data to the ResponseError sent to the client.
+func PrepareRename(ctx context.Context, snapshot Snapshot, f FileHandle, pp protocol.Position) (*PrepareRenameResult, error) {
+	pkg, pgf, err := NarrowestPackageForFile(ctx, snapshot, f.URI())
+	if err != nil {
+		return nil, err
+	}
+	pos, err := pgf.PositionPos(pp)
+	if err != nil {
+		return nil, err
+	}
+	obj, isPackageName, err := objectOrPackageNameAt(pkg, pgf, pos)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Name() == "main" && isPackageName {
+		return nil, &RenameConflictError{Conflicts: []*RenameConflict{newMainPackageConflict()}}
+	}
+	if pkg.Module() == nil {
+		return nil, &RenameConflictError{Conflicts: []*RenameConflict{newUnknownModuleConflict(string(pkg.PkgPath()))}}
+	}
+	if !isPackageName && obj == nil {
+		return nil, &RenameConflictError{Conflicts: []*RenameConflict{newNoObjectConflict()}}
+	}
+
+	rng, text, err := renameRangeAndText(pgf, pos, obj, isPackageName)
+	if err != nil {
+		return nil, err
+	}
+	return &PrepareRenameResult{Range: rng, Text: text}, nil
+}
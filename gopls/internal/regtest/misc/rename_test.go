@@ -5,14 +5,34 @@
 package misc
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 
+	"golang.org/x/tools/gopls/internal/lsp/command"
 	"golang.org/x/tools/gopls/internal/lsp/protocol"
 	. "golang.org/x/tools/gopls/internal/lsp/regtest"
+	"golang.org/x/tools/internal/jsonrpc2"
 	"golang.org/x/tools/internal/testenv"
 )
 
+// renameConflicts extracts the structured conflict data that the server
+// attaches to the ResponseError for a rename/prepareRename call that
+// failed because of a *source.RenameConflictError, so tests can assert on
+// the typed conflict rather than grep the error string.
+func renameConflicts(t *testing.T, err error) []command.ConflictReport {
+	t.Helper()
+	rpcErr, ok := err.(*jsonrpc2.Error)
+	if !ok || rpcErr.Data == nil {
+		t.Fatalf("error %v (%T) does not carry structured rename conflict data", err, err)
+	}
+	var conflicts []command.ConflictReport
+	if err := json.Unmarshal(*rpcErr.Data, &conflicts); err != nil {
+		t.Fatalf("unmarshalling rename conflict data: %v", err)
+	}
+	return conflicts
+}
+
 func TestPrepareRenameMainPackage(t *testing.T) {
 	const files = `
 -- go.mod --
@@ -30,7 +50,6 @@ func main() {
 	fmt.Println(1)
 }
 `
-	const wantErr = "can't rename package \"main\""
 	Run(t, files, func(t *testing.T, env *Env) {
 		env.OpenFile("main.go")
 		pos := env.RegexpSearch("main.go", `main`)
@@ -43,11 +62,12 @@ func main() {
 		}
 		_, err := env.Editor.Server.PrepareRename(env.Ctx, params)
 		if err == nil {
-			t.Errorf("missing can't rename package main error from PrepareRename")
+			t.Fatal("missing can't rename package main error from PrepareRename")
 		}
 
-		if err.Error() != wantErr {
-			t.Errorf("got %v, want %v", err.Error(), wantErr)
+		conflicts := renameConflicts(t, err)
+		if len(conflicts) != 1 || conflicts[0].Message != `can't rename package "main"` {
+			t.Errorf("got conflicts %+v, want a single invalid-target conflict for package \"main\"", conflicts)
 		}
 	})
 }
@@ -74,18 +94,18 @@ func main() {
 	fmt.Println("Hello")
 }
 `
-	const wantErr = "no object found"
 	Run(t, files, func(t *testing.T, env *Env) {
 		env.OpenFile("lib/a.go")
 		pos := env.RegexpSearch("lib/a.go", "fmt")
 
 		err := env.Editor.Rename(env.Ctx, "lib/a.go", pos, "fmt1")
 		if err == nil {
-			t.Errorf("missing no object found from Rename")
+			t.Fatal("missing no object found from Rename")
 		}
 
-		if err.Error() != wantErr {
-			t.Errorf("got %v, want %v", err.Error(), wantErr)
+		conflicts := renameConflicts(t, err)
+		if len(conflicts) != 1 || conflicts[0].Message != "no object found" {
+			t.Errorf("got conflicts %+v, want a single invalid-target conflict with message %q", conflicts, "no object found")
 		}
 	})
 }
@@ -121,12 +141,22 @@ func main() {
 			TextDocumentPositionParams: tdpp,
 		}
 		_, err := env.Editor.Server.PrepareRename(env.Ctx, params)
-		if err == nil || !strings.Contains(err.Error(), wantErr) {
-			t.Errorf("missing cannot rename packages with unknown module from PrepareRename")
+		if err == nil {
+			t.Fatal("missing cannot rename packages with unknown module from PrepareRename")
+		}
+
+		conflicts := renameConflicts(t, err)
+		if len(conflicts) != 1 || conflicts[0].Kind != "invalid rename target" || !strings.HasPrefix(conflicts[0].Message, wantErr) {
+			t.Errorf("got conflicts %+v, want a single invalid-target conflict whose message starts with %q", conflicts, wantErr)
 		}
 	})
 }
 
+// TestRenamePackageWithConflicts renames a package to a name that
+// collides with an existing unaliased import (mod.com/lib/nested), in a
+// file that also holds an existing alias (nested1), and uses RenamePreview, per its doc
+// comment, to assert on the exact edit set produced rather than
+// re-grepping buffers for substrings that happen to appear.
 func TestRenamePackageWithConflicts(t *testing.T) {
 	testenv.NeedsGo1Point(t, 17)
 	const files = `
@@ -165,13 +195,39 @@ func main() {
 	Run(t, files, func(t *testing.T, env *Env) {
 		env.OpenFile("lib/a.go")
 		pos := env.RegexpSearch("lib/a.go", "lib")
-		env.Rename("lib/a.go", pos, "nested")
 
-		// Check if the new package name exists.
-		env.RegexpSearch("nested/a.go", "package nested")
-		env.RegexpSearch("main.go", `nested2 "mod.com/nested"`)
-		env.RegexpSearch("main.go", "mod.com/nested/nested")
-		env.RegexpSearch("main.go", `nested1 "mod.com/nested/x"`)
+		result, err := env.Editor.RenamePreview(env.Ctx, "lib/a.go", pos, "nested")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Conflicts) != 0 {
+			t.Fatalf("got conflicts %+v, want none (the collision is resolved by aliasing)", result.Conflicts)
+		}
+
+		var mainEdits []protocol.TextEdit
+		for _, dc := range result.Edit.DocumentChanges {
+			if dc.TextDocumentEdit == nil || !strings.HasSuffix(string(dc.TextDocumentEdit.TextDocument.URI), "main.go") {
+				continue
+			}
+			mainEdits = append(mainEdits, dc.TextDocumentEdit.Edits...)
+		}
+
+		wantTexts := map[string]bool{
+			`"mod.com/nested"`:        false, // mod.com/lib: its own import path is rewritten
+			`nested2 `:                false, // mod.com/lib: its new name "nested" collides with mod.com/lib/nested's, so it's aliased
+			`"mod.com/nested/nested"`: false, // mod.com/lib/nested: only its path changes
+			`"mod.com/nested/x"`:      false, // mod.com/lib/x: already aliased as nested1, so only its path changes
+		}
+		for _, e := range mainEdits {
+			if _, ok := wantTexts[e.NewText]; ok {
+				wantTexts[e.NewText] = true
+			}
+		}
+		for text, found := range wantTexts {
+			if !found {
+				t.Errorf("main.go edits %+v missing expected replacement text %q", mainEdits, text)
+			}
+		}
 	})
 }
 
@@ -547,6 +603,64 @@ func main() {
 	})
 }
 
+// Test that renaming the directory that is the root of a nested module
+// (rather than merely a directory that is replaced-to) updates the
+// nested module's own `module` directive, the outer module's `require`
+// line, and the `replace` directive's path target, all together.
+func TestRenameNestedModuleItself(t *testing.T) {
+	testenv.NeedsGo1Point(t, 17)
+	const files = `
+-- go.mod --
+module mod.com
+
+go 1.18
+
+require (
+    mod.com/foo/bar v0.0.0
+)
+
+replace mod.com/foo/bar => ./foo/bar
+-- foo/foo.go --
+package foo
+
+import "mod.com/foo/bar"
+
+func UseBar() string {
+	return bar.Msg
+}
+
+-- foo/bar/go.mod --
+module mod.com/foo/bar
+-- foo/bar/bar.go --
+package bar
+
+const Msg = "Hi"
+
+-- main.go --
+package main
+
+import (
+	"fmt"
+
+	"mod.com/foo"
+)
+
+func main() {
+	fmt.Println(foo.UseBar())
+}
+`
+	Run(t, files, func(t *testing.T, env *Env) {
+		env.OpenFile("foo/bar/bar.go")
+		pos := env.RegexpSearch("foo/bar/bar.go", "bar")
+		env.Rename("foo/bar/bar.go", pos, "barx")
+
+		env.RegexpSearch("foo/barx/go.mod", "module mod.com/foo/barx")
+		env.RegexpSearch("go.mod", "mod.com/foo/barx v0.0.0")
+		env.RegexpSearch("go.mod", `replace mod.com/foo/barx => \./foo/barx`)
+		env.RegexpSearch("foo/foo.go", "mod.com/foo/barx")
+	})
+}
+
 func TestRenamePackageWithNonBlankSameImportPaths(t *testing.T) {
 	testenv.NeedsGo1Point(t, 17)
 	const files = `
@@ -590,6 +704,154 @@ func main() {
 	})
 }
 
+// Test the gopls.rename_preview command: it must return the same edits
+// that a real rename would apply, a per-file summary, and must not touch
+// any open buffer.
+func TestRenamePreview(t *testing.T) {
+	const files = `
+-- go.mod --
+module mod.com
+
+go 1.18
+-- a.go --
+package a
+
+const X = 1
+-- b.go --
+package a
+
+func F() int {
+	return X
+}
+`
+	Run(t, files, func(t *testing.T, env *Env) {
+		env.OpenFile("a.go")
+		before := env.Editor.BufferText("a.go")
+
+		pos := env.RegexpSearch("a.go", "X")
+		result, err := env.Editor.RenamePreview(env.Ctx, "a.go", pos, "Y")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Summary) != 2 {
+			t.Fatalf("got %d files in preview summary, want 2 (a.go and b.go): %+v", len(result.Summary), result.Summary)
+		}
+		for _, s := range result.Summary {
+			if s.EditCount == 0 {
+				t.Errorf("file %s has zero edits in preview summary", s.URI)
+			}
+		}
+
+		if got := env.Editor.BufferText("a.go"); got != before {
+			t.Errorf("RenamePreview modified the open buffer for a.go; got:\n%s\nwant:\n%s", got, before)
+		}
+	})
+}
+
+// Test that renaming an exported interface method updates every
+// implementation of that method, even when the satisfying type lives in a
+// separate package from both the interface and the call site. This
+// exercises the transitive reverse-dependency walk, since the method is
+// reachable only through interface satisfaction, not a direct import.
+func TestRenameMethodAcrossInterfaceSatisfaction(t *testing.T) {
+	testenv.NeedsGo1Point(t, 18)
+	const files = `
+-- go.mod --
+module mod.com
+
+go 1.18
+-- iface/iface.go --
+package iface
+
+type Greeter interface {
+	Greet() string
+}
+-- impl/impl.go --
+package impl
+
+type EnglishGreeter struct{}
+
+func (EnglishGreeter) Greet() string {
+	return "hello"
+}
+-- main.go --
+package main
+
+import (
+	"fmt"
+
+	"mod.com/iface"
+	"mod.com/impl"
+)
+
+func greet(g iface.Greeter) {
+	fmt.Println(g.Greet())
+}
+
+func main() {
+	greet(impl.EnglishGreeter{})
+}
+`
+	Run(t, files, func(t *testing.T, env *Env) {
+		env.OpenFile("iface/iface.go")
+		pos := env.RegexpSearch("iface/iface.go", "Greet")
+		env.Rename("iface/iface.go", pos, "SayHello")
+
+		env.RegexpSearch("iface/iface.go", "SayHello")
+		env.RegexpSearch("impl/impl.go", "func \\(EnglishGreeter\\) SayHello")
+		env.RegexpSearch("main.go", "g\\.SayHello\\(\\)")
+	})
+}
+
+// Test that renaming an exported struct field updates references reached
+// through an embedded struct, which is promoted into the embedding type's
+// method/field set and so must be found even in packages that only
+// reference the embedding type.
+func TestRenameFieldAcrossEmbedding(t *testing.T) {
+	testenv.NeedsGo1Point(t, 18)
+	const files = `
+-- go.mod --
+module mod.com
+
+go 1.18
+-- base/base.go --
+package base
+
+type Base struct {
+	Name string
+}
+-- derived/derived.go --
+package derived
+
+import "mod.com/base"
+
+type Derived struct {
+	base.Base
+}
+-- main.go --
+package main
+
+import (
+	"fmt"
+
+	"mod.com/derived"
+)
+
+func main() {
+	d := derived.Derived{}
+	fmt.Println(d.Name)
+}
+`
+	Run(t, files, func(t *testing.T, env *Env) {
+		env.OpenFile("base/base.go")
+		pos := env.RegexpSearch("base/base.go", "Name")
+		env.Rename("base/base.go", pos, "FullName")
+
+		env.RegexpSearch("base/base.go", "FullName")
+		env.RegexpSearch("main.go", "d\\.FullName")
+	})
+}
+
 func TestRenamePackageWithBlankSameImportPaths(t *testing.T) {
 	testenv.NeedsGo1Point(t, 17)
 	const files = `